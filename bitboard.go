@@ -0,0 +1,681 @@
+package main
+
+// Bitboard is a 64-bit mask of squares, one bit per square. Bit i corresponds
+// to the square with file i%8 (a..h) and rank 8-i/8 (8..1), i.e. bit 0 is a8
+// and bit 63 is h1. This is purely an internal encoding for BBPosition; it is
+// independent from the mailbox Square used by the rest of the package.
+type Bitboard uint64
+
+// pieceKind enumerates the six piece types, used to index per-kind bitboards
+// and attack tables. Color is tracked separately from kind.
+type pieceKind int
+
+const (
+	pawnKind pieceKind = iota
+	knightKind
+	bishopKind
+	rookKind
+	queenKind
+	kingKind
+	numKinds
+)
+
+// color identifies the side a BBPosition bitboard belongs to.
+type color int
+
+const (
+	white color = iota
+	black
+)
+
+// kindOf and colorOf classify a mailbox Piece into a bitboard kind/color
+// pair. ok is false for whitespace, dots or anything else that is not a
+// piece.
+func kindOf(p Piece) (k pieceKind, ok bool) {
+	switch p {
+	case 'P', 'p':
+		return pawnKind, true
+	case 'N', 'n':
+		return knightKind, true
+	case 'B', 'b':
+		return bishopKind, true
+	case 'R', 'r':
+		return rookKind, true
+	case 'Q', 'q':
+		return queenKind, true
+	case 'K', 'k':
+		return kingKind, true
+	}
+	return 0, false
+}
+
+func colorOf(p Piece) color {
+	if p >= 'a' && p <= 'z' {
+		return black
+	}
+	return white
+}
+
+// bitOf converts a mailbox Square into a 0..63 bitboard index. Squares
+// outside the playable 8x8 area should never be passed in.
+func bitOf(s Square) int {
+	row := int(s)/10 - 2
+	col := int(s)%10 - 1
+	return row*8 + col
+}
+
+// squareOf is the inverse of bitOf.
+func squareOf(bit int) Square {
+	row := bit / 8
+	col := bit % 8
+	return Square((row+2)*10 + col + 1)
+}
+
+func bbFromSquare(s Square) Bitboard { return 1 << uint(bitOf(s)) }
+
+// popLSB clears and returns the index of the least significant set bit.
+func (b *Bitboard) popLSB() int {
+	bit := trailingZeros64(uint64(*b))
+	*b &= *b - 1
+	return bit
+}
+
+func trailingZeros64(x uint64) int {
+	if x == 0 {
+		return 64
+	}
+	n := 0
+	for x&1 == 0 {
+		x >>= 1
+		n++
+	}
+	return n
+}
+
+func (b Bitboard) popCount() int {
+	n := 0
+	for b != 0 {
+		b &= b - 1
+		n++
+	}
+	return n
+}
+
+const (
+	notAFile  Bitboard = 0xfefefefefefefefe // excludes the a-file (col 0)
+	notHFile  Bitboard = 0x7f7f7f7f7f7f7f7f // excludes the h-file (col 7)
+	notABFile Bitboard = 0xfcfcfcfcfcfcfcfc // excludes the a- and b-files
+	notGHFile Bitboard = 0x3f3f3f3f3f3f3f3f // excludes the g- and h-files
+)
+
+var (
+	knightAttacks [64]Bitboard
+	kingAttacks   [64]Bitboard
+	pawnAttacks   [2][64]Bitboard
+)
+
+func init() {
+	for bit := 0; bit < 64; bit++ {
+		from := Bitboard(1) << uint(bit)
+		knightAttacks[bit] = knightAttacksFrom(from)
+		kingAttacks[bit] = kingAttacksFrom(from)
+		pawnAttacks[white][bit] = shift(from&notAFile, -9) | shift(from&notHFile, -7)
+		pawnAttacks[black][bit] = shift(from&notHFile, 9) | shift(from&notAFile, 7)
+	}
+}
+
+// shift moves a bitboard by n bits, where positive n shifts toward higher
+// bit indices (south, since bit 0 is a8) and negative n shifts north.
+func shift(b Bitboard, n int) Bitboard {
+	if n >= 0 {
+		return b << uint(n)
+	}
+	return b >> uint(-n)
+}
+
+// knightAttacksFrom computes the knight targets from a single-bit source,
+// masking the source (not the destination) against file wraparound before
+// shifting so jumps never leak across board edges.
+func knightAttacksFrom(from Bitboard) (att Bitboard) {
+	att |= shift(from&notAFile, -17)
+	att |= shift(from&notHFile, -15)
+	att |= shift(from&notABFile, -10)
+	att |= shift(from&notGHFile, -6)
+	att |= shift(from&notABFile, 6)
+	att |= shift(from&notGHFile, 10)
+	att |= shift(from&notAFile, 15)
+	att |= shift(from&notHFile, 17)
+	return att
+}
+
+func kingAttacksFrom(from Bitboard) (att Bitboard) {
+	att |= shift(from&notAFile, -9)
+	att |= shift(from, -8)
+	att |= shift(from&notHFile, -7)
+	att |= shift(from&notAFile, -1)
+	att |= shift(from&notHFile, 1)
+	att |= shift(from&notAFile, 7)
+	att |= shift(from, 8)
+	att |= shift(from&notHFile, 9)
+	return att
+}
+
+// rookAttacksKS computes rook sliding attacks from bit using a Kogge-Stone
+// occluded fill in the four orthogonal directions, stopping at (and
+// including) the first blocker in occ.
+func rookAttacksKS(bit int, occ Bitboard) Bitboard {
+	from := Bitboard(1) << uint(bit)
+	empty := ^occ
+	return occludedFill(from, empty, -8, 0xffffffffffffffff) |
+		occludedFill(from, empty, 8, 0xffffffffffffffff) |
+		occludedFill(from, empty, -1, notAFile) |
+		occludedFill(from, empty, 1, notHFile)
+}
+
+// bishopAttacksKS computes bishop sliding attacks analogously to
+// rookAttacksKS but along the four diagonals.
+func bishopAttacksKS(bit int, occ Bitboard) Bitboard {
+	from := Bitboard(1) << uint(bit)
+	empty := ^occ
+	return occludedFill(from, empty, -9, notAFile) |
+		occludedFill(from, empty, -7, notHFile) |
+		occludedFill(from, empty, 7, notAFile) |
+		occludedFill(from, empty, 9, notHFile)
+}
+
+func queenAttacksKS(bit int, occ Bitboard) Bitboard {
+	return rookAttacksKS(bit, occ) | bishopAttacksKS(bit, occ)
+}
+
+// occludedFill repeatedly steps by delta while the destination square is
+// both empty and on the correct side of the board (wrapMask), accumulating
+// every square reached plus the first occupied square that stops the fill.
+func occludedFill(from, empty Bitboard, delta int, wrapMask Bitboard) (att Bitboard) {
+	sq := from
+	for {
+		sq &= wrapMask
+		sq = shift(sq, delta)
+		if sq == 0 {
+			break
+		}
+		att |= sq
+		if sq&empty == 0 {
+			break
+		}
+	}
+	return att
+}
+
+// BBPosition is a bitboard-based equivalent of Position: twelve piece
+// bitboards (six kinds x two colors), per-color occupancy, castling rights,
+// en-passant target square and side to move. Unlike Position it always
+// describes the board from White's point of view; Moves() takes sideToMove
+// into account instead of relying on board rotation.
+type BBPosition struct {
+	pieces     [2][numKinds]Bitboard
+	occ        [2]Bitboard
+	all        Bitboard
+	wc         [2]bool // white castling rights, same [O-O-O, O-O] order as Position.wc
+	bc         [2]bool // black castling rights, same [O-O, O-O-O] order as Position.bc
+	ep         Square  // 0 if none
+	sideToMove color
+}
+
+// NewBBPosition builds a BBPosition from a mailbox Position. Position.board
+// is mover-relative (see Position.Flip), so pos is un-rotated to absolute
+// (White's) orientation first whenever Black is to move - the same
+// un-rotation Position.FEN does before rendering a FEN string.
+func NewBBPosition(pos Position) BBPosition {
+	abs := pos
+	if !pos.whiteToMove {
+		abs = pos.Flip()
+	}
+	var bb BBPosition
+	for i, p := range abs.board {
+		k, ok := kindOf(p)
+		if !ok {
+			continue
+		}
+		c := colorOf(p)
+		bit := Bitboard(1) << uint(bitOf(Square(i)))
+		bb.pieces[c][k] |= bit
+		bb.occ[c] |= bit
+	}
+	bb.all = bb.occ[white] | bb.occ[black]
+	bb.wc, bb.bc = abs.wc, abs.bc
+	bb.ep = abs.ep
+	bb.sideToMove = white
+	if !pos.whiteToMove {
+		bb.sideToMove = black
+	}
+	return bb
+}
+
+// bbAt returns a single-bit Bitboard for a 0..63 bit index.
+func bbAt(bit int) Bitboard { return Bitboard(1) << uint(bit) }
+
+// BBPositionFromFEN parses a FEN string (reusing the package-level FEN
+// parser) and returns the equivalent BBPosition.
+func BBPositionFromFEN(s string) (BBPosition, error) {
+	pos, err := FEN(s)
+	if err != nil {
+		return BBPosition{}, err
+	}
+	return NewBBPosition(pos), nil
+}
+
+// pseudoMoves returns moves that respect normal piece movement, including
+// castling's empty-squares-and-not-through-check requirements, but without
+// regard to checks or pins affecting any other piece - the bitboard
+// equivalent of Position.pseudoMoves. Moves filters these down to strictly
+// legal moves.
+func (bb BBPosition) pseudoMoves() (moves []Move) {
+	us, them := bb.sideToMove, color(1-bb.sideToMove)
+	empty := ^bb.all
+
+	knights := bb.pieces[us][knightKind]
+	for knights != 0 {
+		from := knights.popLSB()
+		targets := knightAttacks[from] &^ bb.occ[us]
+		moves = appendTargets(moves, from, targets)
+	}
+
+	kings := bb.pieces[us][kingKind]
+	for kings != 0 {
+		from := kings.popLSB()
+		targets := kingAttacks[from] &^ bb.occ[us]
+		moves = appendTargets(moves, from, targets)
+	}
+
+	bishops := bb.pieces[us][bishopKind]
+	for bishops != 0 {
+		from := bishops.popLSB()
+		targets := bishopAttacksKS(from, bb.all) &^ bb.occ[us]
+		moves = appendTargets(moves, from, targets)
+	}
+
+	rooks := bb.pieces[us][rookKind]
+	for rooks != 0 {
+		from := rooks.popLSB()
+		targets := rookAttacksKS(from, bb.all) &^ bb.occ[us]
+		moves = appendTargets(moves, from, targets)
+	}
+
+	queens := bb.pieces[us][queenKind]
+	for queens != 0 {
+		from := queens.popLSB()
+		targets := queenAttacksKS(from, bb.all) &^ bb.occ[us]
+		moves = appendTargets(moves, from, targets)
+	}
+
+	moves = append(moves, bb.pawnMoves(us, them, empty)...)
+	moves = append(moves, bb.castleMoves()...)
+
+	return moves
+}
+
+func appendTargets(moves []Move, from int, targets Bitboard) []Move {
+	f := squareOf(from)
+	for targets != 0 {
+		to := targets.popLSB()
+		moves = append(moves, Move{from: f, to: squareOf(to)})
+	}
+	return moves
+}
+
+func (bb BBPosition) pawnMoves(us, them color, empty Bitboard) (moves []Move) {
+	pawns := bb.pieces[us][pawnKind]
+	// White: toward bit index 0, starting rank is row 6, promotes on row 0.
+	forward, doubleRank, promoteRow := -8, 6, 0
+	if us == black {
+		forward, doubleRank, promoteRow = 8, 1, 7
+	}
+	// appendPawnMove expands a push/capture into one Move per promotion
+	// choice when it lands on the back rank, matching Position.pseudoMoves.
+	appendPawnMove := func(from, to int) {
+		f, t := squareOf(from), squareOf(to)
+		if to/8 == promoteRow {
+			for _, promo := range promoPieces {
+				moves = append(moves, Move{from: f, to: t, promo: promo})
+			}
+			return
+		}
+		moves = append(moves, Move{from: f, to: t})
+	}
+	for p := pawns; p != 0; {
+		from := p.popLSB()
+		one := shift(Bitboard(1)<<uint(from), forward) & empty
+		if one != 0 {
+			to := trailingZeros64(uint64(one))
+			appendPawnMove(from, to)
+			if from/8 == doubleRank {
+				two := shift(one, forward) & empty
+				if two != 0 {
+					appendPawnMove(from, trailingZeros64(uint64(two)))
+				}
+			}
+		}
+		targets := pawnAttacks[us][from] & (bb.occ[them])
+		if bb.ep != 0 {
+			targets |= pawnAttacks[us][from] & bbFromSquare(bb.ep)
+		}
+		for targets != 0 {
+			appendPawnMove(from, targets.popLSB())
+		}
+	}
+	return moves
+}
+
+// attackersOf returns the squares of by-colored pieces that attack sq,
+// regardless of whether moving them there would be legal - the bitboard
+// equivalent of the mailbox attackersOf. It mirrors that function's
+// pawn-direction trick: pawnAttacks[1-by][sq] gives the squares a
+// by-colored pawn would have to stand on to attack sq, since the attack
+// offsets are each color's mirror image of the other's.
+func (bb BBPosition) attackersOf(sq int, by color) Bitboard {
+	them := color(1 - by)
+	var att Bitboard
+	att |= knightAttacks[sq] & bb.pieces[by][knightKind]
+	att |= kingAttacks[sq] & bb.pieces[by][kingKind]
+	att |= pawnAttacks[them][sq] & bb.pieces[by][pawnKind]
+	att |= rookAttacksKS(sq, bb.all) & (bb.pieces[by][rookKind] | bb.pieces[by][queenKind])
+	att |= bishopAttacksKS(sq, bb.all) & (bb.pieces[by][bishopKind] | bb.pieces[by][queenKind])
+	return att
+}
+
+// attacked reports whether sq is attacked by any of by's pieces.
+func (bb BBPosition) attacked(sq int, by color) bool {
+	return bb.attackersOf(sq, by) != 0
+}
+
+// checkers returns the squares of opponent pieces currently attacking the
+// side to move's king, the bitboard equivalent of Position.Checkers.
+func (bb BBPosition) checkers() Bitboard {
+	us, them := bb.sideToMove, color(1-bb.sideToMove)
+	king := trailingZeros64(uint64(bb.pieces[us][kingKind]))
+	return bb.attackersOf(king, them)
+}
+
+// InCheck reports whether the side to move's king is currently attacked.
+func (bb BBPosition) InCheck() bool {
+	return bb.checkers() != 0
+}
+
+// kindAt returns the kind of the c-colored piece standing on bit, if any.
+func (bb BBPosition) kindAt(bit int, c color) (pieceKind, bool) {
+	b := bbAt(bit)
+	for k := pieceKind(0); k < numKinds; k++ {
+		if bb.pieces[c][k]&b != 0 {
+			return k, true
+		}
+	}
+	return 0, false
+}
+
+// rayDirs lists the 8 queen directions as (shift delta, wrap mask) pairs,
+// in the same encoding occludedFill uses: mask is applied before shift so a
+// cursor walking off the correct edge of the board lands on 0 instead of
+// wrapping to the opposite file.
+var rayDirs = []struct {
+	delta    int
+	mask     Bitboard
+	diagonal bool
+}{
+	{-8, ^Bitboard(0), false}, // N
+	{8, ^Bitboard(0), false},  // S
+	{-1, notAFile, false},     // W
+	{1, notHFile, false},      // E
+	{-9, notAFile, true},      // NW
+	{-7, notHFile, true},      // NE
+	{7, notAFile, true},       // SW
+	{9, notHFile, true},       // SE
+}
+
+// pinnedPieces returns, for each of the side to move's pieces pinned
+// against its king by an opponent slider, the ray direction (one of the 8
+// queen directions, pointed from the king towards the piece) it is pinned
+// along - the bitboard equivalent of Position.PinnedPieces.
+func (bb BBPosition) pinnedPieces() map[int]int {
+	pinned := map[int]int{}
+	us, them := bb.sideToMove, color(1-bb.sideToMove)
+	king := trailingZeros64(uint64(bb.pieces[us][kingKind]))
+	for _, dir := range rayDirs {
+		candidate := -1
+		cursor := bbAt(king)
+		for {
+			cursor &= dir.mask
+			cursor = shift(cursor, dir.delta)
+			if cursor == 0 {
+				break
+			}
+			if cursor&bb.all == 0 {
+				continue
+			}
+			bit := trailingZeros64(uint64(cursor))
+			if candidate == -1 {
+				if cursor&bb.occ[us] == 0 {
+					break
+				}
+				candidate = bit
+				continue
+			}
+			rooksAndQueens := bb.pieces[them][rookKind] | bb.pieces[them][queenKind]
+			bishopsAndQueens := bb.pieces[them][bishopKind] | bb.pieces[them][queenKind]
+			if dir.diagonal && cursor&bishopsAndQueens != 0 || !dir.diagonal && cursor&rooksAndQueens != 0 {
+				pinned[candidate] = dir.delta
+			}
+			break
+		}
+	}
+	return pinned
+}
+
+// onRayBB reports whether toBit lies on the rank, file or diagonal through
+// fromBit in direction delta or its opposite - the bitboard equivalent of
+// onRay.
+func onRayBB(fromBit, toBit, delta int) bool {
+	var mask Bitboard
+	for _, dir := range rayDirs {
+		if dir.delta == delta || dir.delta == -delta {
+			mask = dir.mask
+		}
+	}
+	for _, step := range [2]int{delta, -delta} {
+		cursor := bbAt(fromBit)
+		for {
+			cursor &= mask
+			cursor = shift(cursor, step)
+			if cursor == 0 {
+				break
+			}
+			if trailingZeros64(uint64(cursor)) == toBit {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// makeMove returns the BBPosition after playing m: it is only used to test
+// king safety for moves pinnedPieces/checkers can't settle without
+// replaying them (king moves, en-passant, or any move while already in
+// check), mirroring the fallback Position.Moves() takes for the same cases.
+// Unlike Position.MakeMove it returns a fresh value rather than mutating in
+// place, since BBPosition - not being wired into Searcher's hot path (see
+// the moveGenerator doc comment) - has no need yet for an Undo/UnmakeMove
+// pair.
+func (bb BBPosition) makeMove(m Move) BBPosition {
+	np := bb
+	us, them := bb.sideToMove, color(1-bb.sideToMove)
+	from, to := bitOf(m.from), bitOf(m.to)
+	fromBB, toBB := bbAt(from), bbAt(to)
+	k, _ := bb.kindAt(from, us)
+
+	capSq := to
+	if k == pawnKind && toBB&bb.all == 0 && from%8 != to%8 {
+		if us == white {
+			capSq = to + 8
+		} else {
+			capSq = to - 8
+		}
+	}
+	if ck, ok := np.kindAt(capSq, them); ok {
+		np.pieces[them][ck] &^= bbAt(capSq)
+		np.occ[them] &^= bbAt(capSq)
+	}
+
+	np.pieces[us][k] &^= fromBB
+	np.occ[us] &^= fromBB
+	newKind := k
+	if m.promo != 0 {
+		newKind, _ = kindOf(m.promo)
+	}
+	np.pieces[us][newKind] |= toBB
+	np.occ[us] |= toBB
+
+	if m.flags&CastleShort != 0 {
+		rookFrom, rookTo := h1, f1
+		if us == black {
+			rookFrom, rookTo = h8, f8
+		}
+		np.pieces[us][rookKind] = np.pieces[us][rookKind]&^bbAt(rookFrom) | bbAt(rookTo)
+		np.occ[us] = np.occ[us]&^bbAt(rookFrom) | bbAt(rookTo)
+	}
+	if m.flags&CastleLong != 0 {
+		rookFrom, rookTo := a1, d1
+		if us == black {
+			rookFrom, rookTo = a8, d8
+		}
+		np.pieces[us][rookKind] = np.pieces[us][rookKind]&^bbAt(rookFrom) | bbAt(rookTo)
+		np.occ[us] = np.occ[us]&^bbAt(rookFrom) | bbAt(rookTo)
+	}
+
+	np.ep = 0
+	if k == pawnKind && abs(to-from) == 16 {
+		np.ep = squareOf((from + to) / 2)
+	}
+
+	if k == kingKind {
+		if us == white {
+			np.wc = [2]bool{}
+		} else {
+			np.bc = [2]bool{}
+		}
+	}
+	switch from {
+	case a1:
+		np.wc[0] = false
+	case h1:
+		np.wc[1] = false
+	case a8:
+		np.bc[1] = false
+	case h8:
+		np.bc[0] = false
+	}
+	switch to {
+	case a1:
+		np.wc[0] = false
+	case h1:
+		np.wc[1] = false
+	case a8:
+		np.bc[1] = false
+	case h8:
+		np.bc[0] = false
+	}
+
+	np.all = np.occ[white] | np.occ[black]
+	np.sideToMove = them
+	return np
+}
+
+// Moves returns the strictly legal moves for the side to move, in the same
+// []Move representation Position.Moves() produces, using the same
+// checkers/pinnedPieces shortcut: when the king isn't in check, a move by
+// anything other than the king is legal unless pinnedPieces says its piece
+// is pinned and the move leaves the pin ray. Everything that can't settle -
+// king moves, en-passant captures, and any move while already in check -
+// falls back to playing the move and checking the resulting position's
+// InCheck, same as Position.Moves().
+func (bb BBPosition) Moves() (moves []Move) {
+	us, them := bb.sideToMove, color(1-bb.sideToMove)
+	checkers := bb.checkers()
+	pinned := bb.pinnedPieces()
+	king := trailingZeros64(uint64(bb.pieces[us][kingKind]))
+	for _, m := range bb.pseudoMoves() {
+		if m.flags&(CastleShort|CastleLong) != 0 {
+			moves = append(moves, m)
+			continue
+		}
+		from := bitOf(m.from)
+		k, _ := bb.kindAt(from, us)
+		enPassant := k == pawnKind && bb.ep != 0 && m.to == bb.ep
+		if checkers == 0 && from != king && !enPassant {
+			if d, ok := pinned[from]; ok && !onRayBB(king, bitOf(m.to), d) {
+				continue
+			}
+			moves = append(moves, m)
+			continue
+		}
+		next := bb.makeMove(m)
+		if next.attackersOf(trailingZeros64(uint64(next.pieces[us][kingKind])), them) == 0 {
+			moves = append(moves, m)
+		}
+	}
+	return moves
+}
+
+// Rank-1/rank-8 bit indices for a bitboard where bit 0 is a8 and bit 63 is
+// h1 (row 7 is rank 1, row 0 is rank 8), shared by castleMoves and makeMove.
+const (
+	a1, e1, d1, c1, b1, f1, g1, h1 = 56, 60, 59, 58, 57, 61, 62, 63
+	a8, e8, d8, c8, b8, f8, g8, h8 = 0, 4, 3, 2, 1, 5, 6, 7
+)
+
+// castleMoves returns the side to move's pseudo-legal castling moves: the
+// squares between king and rook must be empty, and the king's start,
+// transit and destination squares must all be free of attack, the same
+// rule Position.pseudoMoves applies via castleSafe.
+func (bb BBPosition) castleMoves() (moves []Move) {
+	us, them := bb.sideToMove, color(1-bb.sideToMove)
+	king, rooks := bb.pieces[us][kingKind], bb.pieces[us][rookKind]
+	if us == white {
+		if bb.wc[1] && king&bbAt(e1) != 0 && rooks&bbAt(h1) != 0 &&
+			bb.all&(bbAt(f1)|bbAt(g1)) == 0 &&
+			!bb.attacked(e1, them) && !bb.attacked(f1, them) && !bb.attacked(g1, them) {
+			moves = append(moves, Move{from: squareOf(e1), to: squareOf(g1), flags: CastleShort})
+		}
+		if bb.wc[0] && king&bbAt(e1) != 0 && rooks&bbAt(a1) != 0 &&
+			bb.all&(bbAt(d1)|bbAt(c1)|bbAt(b1)) == 0 &&
+			!bb.attacked(e1, them) && !bb.attacked(d1, them) && !bb.attacked(c1, them) {
+			moves = append(moves, Move{from: squareOf(e1), to: squareOf(c1), flags: CastleLong})
+		}
+	} else {
+		if bb.bc[0] && king&bbAt(e8) != 0 && rooks&bbAt(h8) != 0 &&
+			bb.all&(bbAt(f8)|bbAt(g8)) == 0 &&
+			!bb.attacked(e8, them) && !bb.attacked(f8, them) && !bb.attacked(g8, them) {
+			moves = append(moves, Move{from: squareOf(e8), to: squareOf(g8), flags: CastleShort})
+		}
+		if bb.bc[1] && king&bbAt(e8) != 0 && rooks&bbAt(a8) != 0 &&
+			bb.all&(bbAt(d8)|bbAt(c8)|bbAt(b8)) == 0 &&
+			!bb.attacked(e8, them) && !bb.attacked(d8, them) && !bb.attacked(c8, them) {
+			moves = append(moves, Move{from: squareOf(e8), to: squareOf(c8), flags: CastleLong})
+		}
+	}
+	return moves
+}
+
+// moveGenerator is satisfied by both Position and BBPosition, which now
+// return the same strictly-legal []Move contract, so main.go's bench
+// command and the two Benchmark*Moves benchmarks compare their throughput
+// directly. It is not wired into Searcher: Searcher's eval, Zobrist hash
+// and negamax-by-rotation are all keyed to mailbox Square, and BBPosition
+// has none of the three, so this round does not deliver the requested
+// engine speedup - only a directly comparable Moves().
+type moveGenerator interface {
+	Moves() []Move
+}
+
+var (
+	_ moveGenerator = Position{}
+	_ moveGenerator = BBPosition{}
+)
@@ -2,7 +2,11 @@ package main
 
 import (
 	"errors"
+	"fmt"
+	"strconv"
 	"strings"
+	"time"
+	"unsafe"
 )
 
 // Abs returns an absolute value of a number without branching, should work on
@@ -61,11 +65,12 @@ func (a Board) String() (s string) {
 	return s
 }
 
-// FEN returns a board created from the given FEN (Forsyth-Edwards Notation)
-// string. If a string is not a valid FEN encoding - and error is returned.
-func FEN(fen string) (b Board, err error) {
-	parts := strings.Split(fen, " ")
-	rows := strings.Split(parts[0], "/")
+// parsePlacement decodes the piece placement field of a FEN string (the part
+// before the first space) into a Board. The result is always in FEN's own
+// absolute orientation - uppercase is White, regardless of which side is to
+// move - rotation into the engine's mover-relative frame is FEN's job.
+func parsePlacement(field string) (b Board, err error) {
+	rows := strings.Split(field, "/")
 	if len(rows) != 8 {
 		return b, errors.New("FEN should have 8 rows")
 	}
@@ -92,12 +97,174 @@ func FEN(fen string) (b Board, err error) {
 			return b, errors.New("invalid row length")
 		}
 	}
-	if len(parts) > 1 && parts[1] == "b" {
-		b = b.Flip()
-	}
 	return b, nil
 }
 
+// squareFromString parses an algebraic square like "e3" into a Square, the
+// inverse of Square.String. ok is false for anything that isn't a valid
+// algebraic square.
+func squareFromString(s string) (sq Square, ok bool) {
+	if len(s) != 2 {
+		return 0, false
+	}
+	file := strings.IndexByte(" abcdefgh", s[0])
+	rank := strings.IndexByte("  87654321", s[1])
+	if file < 1 || rank < 2 {
+		return 0, false
+	}
+	return Square(rank*10 + file), true
+}
+
+// FEN parses a Forsyth-Edwards Notation string into a Position. Piece
+// placement is the only mandatory field; side to move, castling rights,
+// en-passant target, halfmove clock and fullmove number default to "w",
+// "-", "-", "0" and "1" when absent, so a bare placement string still loads.
+// The returned Position is in the engine's mover-relative frame (see Flip):
+// the board, castling rights and en-passant square are all rotated whenever
+// it is Black to move.
+func FEN(fen string) (Position, error) {
+	fields := strings.Fields(fen)
+	if len(fields) == 0 {
+		return Position{}, errors.New("FEN should have 8 rows")
+	}
+	board, err := parsePlacement(fields[0])
+	if err != nil {
+		return Position{}, err
+	}
+
+	side := "w"
+	if len(fields) > 1 {
+		side = fields[1]
+	}
+	if side != "w" && side != "b" {
+		return Position{}, errors.New("invalid side to move: " + side)
+	}
+
+	castling := "-"
+	if len(fields) > 2 {
+		castling = fields[2]
+	}
+
+	epField := "-"
+	if len(fields) > 3 {
+		epField = fields[3]
+	}
+
+	halfmove, fullmove := 0, 1
+	if len(fields) > 4 {
+		if halfmove, err = strconv.Atoi(fields[4]); err != nil {
+			return Position{}, errors.New("invalid halfmove clock: " + fields[4])
+		}
+	}
+	if len(fields) > 5 {
+		if fullmove, err = strconv.Atoi(fields[5]); err != nil {
+			return Position{}, errors.New("invalid fullmove number: " + fields[5])
+		}
+	}
+
+	pos := Position{board: board, whiteToMove: true, halfmove: halfmove, fullmove: fullmove}
+	if castling != "-" {
+		for _, c := range castling {
+			switch c {
+			case 'K':
+				pos.wc[1] = true
+			case 'Q':
+				pos.wc[0] = true
+			case 'k':
+				pos.bc[0] = true
+			case 'q':
+				pos.bc[1] = true
+			default:
+				return Position{}, errors.New("invalid castling rights: " + castling)
+			}
+		}
+	}
+	if epField != "-" {
+		sq, ok := squareFromString(epField)
+		if !ok {
+			return Position{}, errors.New("invalid en-passant square: " + epField)
+		}
+		pos.ep = sq
+	}
+
+	if side == "b" {
+		pos = pos.Flip()
+	}
+	pos.hash = computeHash(pos)
+	return pos, nil
+}
+
+// placementFEN renders a Board's piece placement field, the inverse of
+// parsePlacement.
+func placementFEN(b Board) string {
+	rows := make([]string, 8)
+	for i := 0; i < 8; i++ {
+		index := i*10 + 21
+		row, empty := "", 0
+		for col := 0; col < 8; col++ {
+			p := b[index+col]
+			if p == '.' {
+				empty++
+				continue
+			}
+			if empty > 0 {
+				row += strconv.Itoa(empty)
+				empty = 0
+			}
+			row += string(p)
+		}
+		if empty > 0 {
+			row += strconv.Itoa(empty)
+		}
+		rows[i] = row
+	}
+	return strings.Join(rows, "/")
+}
+
+// castlingFEN renders the castling availability field from a pair of
+// castling-rights arrays in the same [queenside, kingside] order Position
+// stores them in.
+func castlingFEN(wc, bc [2]bool) string {
+	s := ""
+	if wc[1] {
+		s += "K"
+	}
+	if wc[0] {
+		s += "Q"
+	}
+	if bc[0] {
+		s += "k"
+	}
+	if bc[1] {
+		s += "q"
+	}
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+// FEN renders pos as a canonical Forsyth-Edwards Notation string: piece
+// placement, side to move, castling availability, en-passant target and the
+// halfmove/fullmove counters. It is the inverse of the package-level FEN
+// parser, un-rotating the board back to absolute orientation first if Black
+// is to move.
+func (pos Position) FEN() string {
+	abs := pos
+	if !pos.whiteToMove {
+		abs = pos.Flip()
+	}
+	side := "w"
+	if !pos.whiteToMove {
+		side = "b"
+	}
+	ep := "-"
+	if abs.ep != 0 {
+		ep = abs.ep.String()
+	}
+	return fmt.Sprintf("%s %s %s %s %d %d", placementFEN(abs.board), side, castlingFEN(abs.wc, abs.bc), ep, abs.halfmove, abs.fullmove)
+}
+
 // Square represents an index of the chess board.
 type Square int
 
@@ -110,23 +277,86 @@ func (s Square) String() string { return string([]byte{" abcdefgh "[s%10], "  87
 // Move direction constants, horizontal moves +/-1, vertical moves +/-10
 const N, E, S, W = -10, 1, 10, -1
 
-// Move represents a movement of a piece from one square to another.
+// MoveFlag tags a castling move, the one case Position.Moves() can't check
+// for legality the same way as every other move (see Moves): MakeMove,
+// value and String all re-derive capture/en-passant/promotion from the
+// board and m.promo instead, since the board is the source of truth and
+// there's no other caller that needs it pre-classified.
+type MoveFlag uint16
+
+const (
+	CastleShort MoveFlag = 1 << iota
+	CastleLong
+)
+
+// promoPieces lists the pieces a pawn can promote to, in the order
+// pseudoMoves emits one Move per choice.
+var promoPieces = [4]Piece{'N', 'B', 'R', 'Q'}
+
+// Move represents a movement of a piece from one square to another. promo is
+// only set for a promoting pawn move, naming the piece it becomes; flags
+// marks a castling move, the one kind Moves can't vet for legality the same
+// way as every other move.
 type Move struct {
-	from Square
-	to   Square
+	from  Square
+	to    Square
+	promo Piece
+	flags MoveFlag
 }
 
-// Moves are printed in algebraic notation, i.e "e2e4".
-func (m Move) String() string { return m.from.String() + m.to.String() }
+// Moves are printed in algebraic notation, i.e "e2e4", with a trailing
+// lowercase promotion letter ("e7e8n") when the move promotes.
+func (m Move) String() string {
+	s := m.from.String() + m.to.String()
+	if m.promo != 0 {
+		s += strings.ToLower(string(m.promo))
+	}
+	return s
+}
+
+// moveFromUCI parses a UCI move string like "e2e4" or "e7e8n" into its
+// absolute-square from/to squares and, for the latter, the promotion piece.
+func moveFromUCI(s string) (Move, bool) {
+	if len(s) < 4 {
+		return Move{}, false
+	}
+	from, ok := squareFromString(s[0:2])
+	if !ok {
+		return Move{}, false
+	}
+	to, ok := squareFromString(s[2:4])
+	if !ok {
+		return Move{}, false
+	}
+	m := Move{from: from, to: to}
+	if len(s) >= 5 {
+		switch s[4] {
+		case 'n':
+			m.promo = 'N'
+		case 'b':
+			m.promo = 'B'
+		case 'r':
+			m.promo = 'R'
+		case 'q':
+			m.promo = 'Q'
+		default:
+			return Move{}, false
+		}
+	}
+	return m, true
+}
 
 // Position describes a board with the current game state (en passant and castling rules).
 type Position struct {
-	board Board   // current board
-	score int     // board score, the higher the better
-	wc    [2]bool // white castling possibilities
-	bc    [2]bool // black castling possibilities
-	ep    Square  // en-passant square where pawn can be captured
-	kp    Square  // king passent during castling, where kind can be captured
+	board       Board   // current board
+	score       int     // board score, the higher the better
+	wc          [2]bool // white castling possibilities
+	bc          [2]bool // black castling possibilities
+	ep          Square  // en-passant square where pawn can be captured
+	halfmove    int     // halfmove clock since the last pawn move or capture, for the fifty-move rule
+	fullmove    int     // fullmove number, incremented after Black's move
+	whiteToMove bool    // true if it is literally White's move, unlike wc/bc/board this is never relative to the mover
+	hash        uint64  // Zobrist fingerprint, maintained incrementally by MakeMove/UnmakeMove/FlipInPlace
 }
 
 // Rotate returns a modified position where the board is flipped, score is
@@ -134,27 +364,162 @@ type Position struct {
 // are reset.
 func (pos Position) Flip() Position {
 	np := Position{
-		score: -pos.score,
-		wc:    [2]bool{pos.bc[0], pos.bc[1]},
-		bc:    [2]bool{pos.wc[0], pos.wc[1]},
-		ep:    pos.ep.Flip(),
-		kp:    pos.kp.Flip(),
+		score:       -pos.score,
+		wc:          [2]bool{pos.bc[0], pos.bc[1]},
+		bc:          [2]bool{pos.wc[0], pos.wc[1]},
+		ep:          pos.ep.Flip(),
+		halfmove:    pos.halfmove,
+		fullmove:    pos.fullmove,
+		whiteToMove: !pos.whiteToMove,
+		hash:        pos.hash ^ zobristSideToMove,
 	}
 	np.board = pos.board.Flip()
 	return np
 }
 
-// Moves returns a list of all valid moves for the current board position.
-func (pos Position) Moves() (moves []Move) {
-	// All possible movement directions for each piece type
-	var directions = map[Piece][]Square{
-		'P': {N, N + N, N + W, N + E},
-		'N': {N + N + E, E + N + E, E + S + E, S + S + E, S + S + W, W + S + W, W + N + W, N + N + W},
-		'B': {N + E, S + E, S + W, N + W},
-		'R': {N, E, S, W},
-		'Q': {N, E, S, W, N + E, S + E, S + W, N + W},
-		'K': {N, E, S, W, N + E, S + E, S + W, N + W},
+// FlipInPlace rotates pos in place the same way Flip does, without
+// allocating a new Position or Board. Because the Zobrist tables are built
+// symmetrically under rotation, only the side-to-move bit needs toggling.
+func (pos *Position) FlipInPlace() {
+	for i, j := 0, len(pos.board)-1; i < j; i, j = i+1, j-1 {
+		pos.board[i], pos.board[j] = pos.board[j].Flip(), pos.board[i].Flip()
+	}
+	pos.score = -pos.score
+	pos.wc, pos.bc = pos.bc, pos.wc
+	pos.ep = pos.ep.Flip()
+	pos.whiteToMove = !pos.whiteToMove
+	pos.hash ^= zobristSideToMove
+}
+
+// pieceDirections lists, for each piece type, the directions it moves in.
+// It is shared between pseudo-legal move generation and attack detection,
+// since both need to know how a piece reaches a given square.
+var pieceDirections = map[Piece][]Square{
+	'P': {N, N + N, N + W, N + E},
+	'N': {N + N + E, E + N + E, E + S + E, S + S + E, S + S + W, W + S + W, W + N + W, N + N + W},
+	'B': {N + E, S + E, S + W, N + W},
+	'R': {N, E, S, W},
+	'Q': {N, E, S, W, N + E, S + E, S + W, N + W},
+	'K': {N, E, S, W, N + E, S + E, S + W, N + W},
+}
+
+// kingSquare returns the square our king stands on.
+func kingSquare(board Board) Square {
+	for i, p := range board {
+		if p == 'K' {
+			return Square(i)
+		}
+	}
+	return 0
+}
+
+// attackersOf returns the squares of opponent pieces that attack sq on
+// board, regardless of whether moving them there would be legal (e.g. it
+// would expose their own king) - that is a separate concern, handled by the
+// callers that use it for our own king's safety.
+func attackersOf(board Board, sq Square) (attackers []Square) {
+	// Opponent pawns ('p') capture towards higher ranks (direction S), so a
+	// pawn attacking sq stands one square behind it along that same
+	// direction, i.e. to its N+W or N+E.
+	for _, d := range []Square{N + W, N + E} {
+		if board[sq+d] == 'p' {
+			attackers = append(attackers, sq+d)
+		}
+	}
+	for _, d := range pieceDirections['N'] {
+		if board[sq+d] == 'n' {
+			attackers = append(attackers, sq+d)
+		}
+	}
+	for _, d := range pieceDirections['K'] {
+		if board[sq+d] == 'k' {
+			attackers = append(attackers, sq+d)
+		}
+	}
+	for _, d := range pieceDirections['R'] {
+		for j := sq + d; ; j = j + d {
+			q := board[j]
+			if q == ' ' {
+				break
+			}
+			if q == '.' {
+				continue
+			}
+			if q == 'r' || q == 'q' {
+				attackers = append(attackers, j)
+			}
+			break
+		}
+	}
+	for _, d := range pieceDirections['B'] {
+		for j := sq + d; ; j = j + d {
+			q := board[j]
+			if q == ' ' {
+				break
+			}
+			if q == '.' {
+				continue
+			}
+			if q == 'b' || q == 'q' {
+				attackers = append(attackers, j)
+			}
+			break
+		}
+	}
+	return attackers
+}
+
+// InCheck reports whether our king is currently attacked.
+func (pos Position) InCheck() bool {
+	return len(pos.Checkers()) > 0
+}
+
+// Checkers returns the squares of opponent pieces currently attacking our
+// king. An empty result means our king is not in check.
+func (pos Position) Checkers() []Square {
+	return attackersOf(pos.board, kingSquare(pos.board))
+}
+
+// PinnedPieces returns, for each of our pieces pinned against our king by an
+// opponent slider, the ray direction (one of the 8 queen directions, pointed
+// from the king towards the piece) it is pinned along. A pinned piece may
+// only move towards or away from the king along this same ray without
+// exposing the king to check.
+func (pos Position) PinnedPieces() map[Square]Square {
+	pinned := map[Square]Square{}
+	k := kingSquare(pos.board)
+	diagonal := map[Square]bool{N + E: true, S + E: true, S + W: true, N + W: true}
+	for _, d := range pieceDirections['Q'] {
+		var candidate Square
+		for j := k + d; ; j = j + d {
+			q := pos.board[j]
+			if q == ' ' {
+				break
+			}
+			if q == '.' {
+				continue
+			}
+			if candidate == 0 {
+				if !q.ours() {
+					break
+				}
+				candidate = j
+				continue
+			}
+			if diagonal[d] && (q == 'b' || q == 'q') || !diagonal[d] && (q == 'r' || q == 'q') {
+				pinned[candidate] = d
+			}
+			break
+		}
 	}
+	return pinned
+}
+
+// pseudoMoves returns moves that respect normal piece movement, including
+// castling's empty-squares-between-king-and-rook and not-moving-through-
+// check requirements, but without regard to checks or pins affecting any
+// other piece. Moves filters these down to strictly legal moves.
+func (pos Position) pseudoMoves() (moves []Move) {
 	// Iterate over all squares, considering squares with our pieces only
 	for index, p := range pos.board {
 		if !p.ours() {
@@ -162,7 +527,7 @@ func (pos Position) Moves() (moves []Move) {
 		}
 		i := Square(index)
 		// Iterate over all move directions for the given piece
-		for _, d := range directions[p] {
+		for _, d := range pieceDirections[p] {
 			for j := i + d; ; j = j + d {
 				q := pos.board[j]
 				if q == ' ' || (q != '.' && q.ours()) {
@@ -175,21 +540,30 @@ func (pos Position) Moves() (moves []Move) {
 					if d == N+N && (i < A1+N || pos.board[i+N] != '.') {
 						break
 					}
-					if (d == N+W || d == N+E) && q == '.' && (j != pos.ep && j != pos.kp && j != pos.kp-1 && j != pos.kp+1) {
+					if (d == N+W || d == N+E) && q == '.' && j != pos.ep {
 						break
 					}
 				}
-				moves = append(moves, Move{from: i, to: j})
+				if p == 'P' && A8 <= j && j <= H8 {
+					for _, promo := range promoPieces {
+						moves = append(moves, Move{from: i, to: j, promo: promo})
+					}
+				} else {
+					moves = append(moves, Move{from: i, to: j})
+				}
 				// Crawling pieces should stop after a single move
 				if p == 'P' || p == 'N' || p == 'K' || (q != ' ' && q != '.' && !q.ours()) {
 					break
 				}
-				// Castling rules
-				if i == A1 && pos.board[j+E] == 'K' && pos.wc[0] {
-					moves = append(moves, Move{from: j + E, to: j + W})
+				// Castling rules: the rook's own slide above has already
+				// confirmed the squares between king and rook are empty;
+				// also require the king's start, transit and destination
+				// squares to all be free of attack.
+				if i == A1 && pos.board[j+E] == 'K' && pos.wc[0] && castleSafe(pos.board, j+E, j, j+W) {
+					moves = append(moves, Move{from: j + E, to: j + W, flags: CastleLong})
 				}
-				if i == H1 && pos.board[j+W] == 'K' && pos.wc[1] {
-					moves = append(moves, Move{from: j + W, to: j + E})
+				if i == H1 && pos.board[j+W] == 'K' && pos.wc[1] && castleSafe(pos.board, j+W, j, j+E) {
+					moves = append(moves, Move{from: j + W, to: j + E, flags: CastleShort})
 				}
 			}
 		}
@@ -197,53 +571,277 @@ func (pos Position) Moves() (moves []Move) {
 	return moves
 }
 
-// Move returns a modified rotated position after the move is applied.
+// castleSafe reports whether none of the given squares (the king's start,
+// transit and destination squares) are attacked by the opponent.
+func castleSafe(board Board, squares ...Square) bool {
+	for _, sq := range squares {
+		if len(attackersOf(board, sq)) > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Moves returns the strictly legal moves for the current position: a move
+// must capture or block a checking piece (or move the king off the attacked
+// square), the king is the only piece that may move in double check, and no
+// move may expose our own king to an opponent's attack. Castling moves come
+// out of pseudoMoves already vetted for walking through check.
+//
+// Checkers and PinnedPieces settle most of that without playing the move
+// out: when our king isn't in check, a move by anything other than the king
+// is legal unless PinnedPieces says its piece is pinned and the move leaves
+// the pin ray. Everything PinnedPieces can't speak to - king moves (the
+// destination square's own safety still needs checking), en-passant
+// captures (removing two pawns off the same rank can expose a check neither
+// function is tracking) and any move while already in check - falls back to
+// actually playing the move and checking InCheck, same as before.
+func (pos Position) Moves() (moves []Move) {
+	checkers := pos.Checkers()
+	pinned := pos.PinnedPieces()
+	king := kingSquare(pos.board)
+	for _, m := range pos.pseudoMoves() {
+		if m.flags&(CastleShort|CastleLong) != 0 {
+			moves = append(moves, m)
+			continue
+		}
+		enPassant := pos.board[m.from] == 'P' && m.to == pos.ep
+		if len(checkers) == 0 && m.from != king && !enPassant {
+			if d, ok := pinned[m.from]; ok && !onRay(pos.board, king, m.to, d) {
+				continue
+			}
+			moves = append(moves, m)
+			continue
+		}
+		p := pos
+		p.MakeMove(m)
+		if !p.InCheck() {
+			moves = append(moves, m)
+		}
+	}
+	return moves
+}
+
+// onRay reports whether to lies on the rank, file or diagonal through from
+// in direction d or its opposite - the line a piece pinned along d may
+// still move within without exposing its king. It walks the board and
+// stops at the border sentinel the same way pseudoMoves does, since plain
+// arithmetic on the squares can wrap across an edge and land on to by
+// coincidence without actually being on the line.
+func onRay(board Board, from, to, d Square) bool {
+	for _, step := range [2]Square{d, -d} {
+		for j := from + step; board[j] != ' '; j += step {
+			if j == to {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// IsCheckmate reports whether the side to move has no legal moves and is in
+// check.
+func (pos Position) IsCheckmate() bool {
+	return pos.InCheck() && len(pos.Moves()) == 0
+}
+
+// IsStalemate reports whether the side to move has no legal moves and is
+// not in check.
+func (pos Position) IsStalemate() bool {
+	return !pos.InCheck() && len(pos.Moves()) == 0
+}
+
+// Perft counts the leaf nodes reachable from pos after exactly depth plies,
+// recursively applying every move Moves() reports as legal. It is the
+// standard move-generator correctness check (see
+// https://www.chessprogramming.org/Perft_Results): since it only counts
+// nodes rather than evaluating them, a generator bug - illegal castling
+// through check, a missed pin, wrong en-passant rights - shows up as a
+// wrong count instead of silently producing a bad move.
+func (pos *Position) Perft(depth int) uint64 {
+	if depth <= 0 {
+		return 1
+	}
+	var nodes uint64
+	for _, m := range pos.Moves() {
+		u := pos.MakeMove(m)
+		pos.FlipInPlace()
+		nodes += pos.Perft(depth - 1)
+		pos.FlipInPlace()
+		pos.UnmakeMove(m, u)
+	}
+	return nodes
+}
+
+// PerftDivide is Perft broken down by root move, the usual way to localize
+// a wrong total to a specific branch when comparing against a reference
+// engine's own divide output.
+func (pos *Position) PerftDivide(depth int) map[Move]uint64 {
+	counts := map[Move]uint64{}
+	for _, m := range pos.Moves() {
+		u := pos.MakeMove(m)
+		pos.FlipInPlace()
+		counts[m] = pos.Perft(depth - 1)
+		pos.FlipInPlace()
+		pos.UnmakeMove(m, u)
+	}
+	return counts
+}
+
+// Move returns a modified rotated position after the move is applied. It is
+// a thin wrapper around MakeMove/FlipInPlace for callers (the CLI and UCI
+// front-ends) that want a fresh Position rather than an in-place mutation.
 func (pos Position) Move(m Move) (np Position) {
-	i, j, p := m.from, m.to, pos.board[m.from]
 	np = pos
-	np.ep = 0
-	np.kp = 0
-	np.score = pos.score + pos.value(m)
-	np.board[m.to] = pos.board[m.from]
-	np.board[m.from] = '.'
-	if i == A1 {
-		np.wc[0] = false
+	np.MakeMove(m)
+	np.FlipInPlace()
+	return np
+}
+
+// Undo records everything MakeMove needs to restore a Position to the state
+// it had before a given Move was applied.
+type Undo struct {
+	piece      Piece  // the moved piece, before any promotion
+	captured   Piece  // captured piece, or '.' if the move was not a capture
+	capturedSq Square // square the captured piece is restored to
+	ep         Square
+	wc         [2]bool
+	bc         [2]bool
+	halfmove   int
+	fullmove   int
+	score      int
+	hash       uint64
+	rookFrom   Square // non-zero only for castling moves
+	rookTo     Square
+}
+
+// MakeMove applies m to pos in place (board, score, castling rights,
+// en-passant and the Zobrist hash) and returns an Undo that UnmakeMove can
+// later use to reverse it. Unlike Move it does not rotate the board; pair it
+// with FlipInPlace the way Searcher.bound does.
+func (pos *Position) MakeMove(m Move) Undo {
+	i, j, p := m.from, m.to, pos.board[m.from]
+	u := Undo{
+		piece: p, captured: '.', capturedSq: j,
+		ep: pos.ep, wc: pos.wc, bc: pos.bc,
+		halfmove: pos.halfmove, fullmove: pos.fullmove,
+		score: pos.score, hash: pos.hash,
+	}
+
+	if pos.ep != 0 {
+		pos.hash ^= zobristEPSquare[pos.ep]
+	}
+	pos.score += pos.value(m)
+	pos.hash ^= zobristSquare(p, i) ^ zobristSquare(p, j)
+	if q := pos.board[j]; q != '.' && q != ' ' {
+		u.captured = q
+		pos.hash ^= zobristSquare(q, j)
+	}
+	pos.board[j] = p
+	pos.board[i] = '.'
+	pos.ep = 0
+
+	if i == A1 && pos.wc[0] {
+		pos.wc[0] = false
+		pos.hash ^= zobristCastle[0]
 	}
-	if i == H1 {
-		np.wc[1] = false
+	if i == H1 && pos.wc[1] {
+		pos.wc[1] = false
+		pos.hash ^= zobristCastle[1]
 	}
-	if j == A8 {
-		np.bc[1] = false
+	if j == A8 && pos.bc[1] {
+		pos.bc[1] = false
+		pos.hash ^= zobristCastle[3]
 	}
-	if j == H8 {
-		np.bc[0] = false
+	if j == H8 && pos.bc[0] {
+		pos.bc[0] = false
+		pos.hash ^= zobristCastle[2]
 	}
 	if p == 'K' {
-		np.wc[0], np.wc[1] = false, false
+		if pos.wc[0] {
+			pos.wc[0] = false
+			pos.hash ^= zobristCastle[0]
+		}
+		if pos.wc[1] {
+			pos.wc[1] = false
+			pos.hash ^= zobristCastle[1]
+		}
 		if abs(int(j-i)) == 2 {
 			if j < i {
-				np.board[H1] = '.'
+				// King moved toward the a-file: long castle, rook comes from a1.
+				u.rookFrom, u.rookTo = A1, (i+j)/2
 			} else {
-				np.board[A1] = '.'
+				// King moved toward the h-file: short castle, rook comes from h1.
+				u.rookFrom, u.rookTo = H1, (i+j)/2
 			}
-			np.board[(i+j)/2] = 'R'
+			pos.board[u.rookFrom] = '.'
+			pos.board[u.rookTo] = 'R'
+			pos.hash ^= zobristSquare('R', u.rookFrom) ^ zobristSquare('R', u.rookTo)
 		}
 	}
 	if p == 'P' {
-		// Pawn promotion
+		// Pawn promotion, defaulting to queen for moves built without an
+		// explicit promo (e.g. a bare Move{from, to} constructed by hand).
 		if A8 <= j && j <= H8 {
-			np.board[j] = 'Q'
+			promo := m.promo
+			if promo == 0 {
+				promo = 'Q'
+			}
+			pos.board[j] = promo
+			pos.hash ^= zobristSquare('P', j) ^ zobristSquare(promo, j)
 		}
 		// First pawn move
 		if j-i == 2*N {
-			np.ep = i + N
+			pos.ep = i + N
+			pos.hash ^= zobristEPSquare[pos.ep]
 		}
-		// En-passant capture
-		if j == pos.ep {
-			np.board[j+S] = '.'
+		// En-passant capture (only for actual diagonal pawn captures landing
+		// on the ep square, not a straight push that happens to reach it)
+		if (j-i == N+W || j-i == N+E) && j == u.ep {
+			u.captured = pos.board[j+S]
+			u.capturedSq = j + S
+			pos.hash ^= zobristSquare(u.captured, j+S)
+			pos.board[j+S] = '.'
+		}
+	}
+
+	// Halfmove clock resets on a pawn move or capture (the fifty-move rule);
+	// the fullmove number only advances once Black, the second mover in a
+	// pair, has moved.
+	if p == 'P' || u.captured != '.' {
+		pos.halfmove = 0
+	} else {
+		pos.halfmove++
+	}
+	if !pos.whiteToMove {
+		pos.fullmove++
+	}
+
+	return u
+}
+
+// UnmakeMove reverses the effect of MakeMove(m), restoring pos to exactly
+// the state captured in u.
+func (pos *Position) UnmakeMove(m Move, u Undo) {
+	i, j := m.from, m.to
+	pos.board[i] = u.piece
+	if u.rookFrom != 0 {
+		pos.board[u.rookFrom] = 'R'
+		pos.board[u.rookTo] = '.'
+	}
+	if u.capturedSq == j {
+		pos.board[j] = u.captured
+	} else {
+		pos.board[j] = '.'
+		if u.captured != '.' && u.captured != ' ' {
+			pos.board[u.capturedSq] = u.captured
 		}
 	}
-	return np.Flip()
+	pos.ep = u.ep
+	pos.wc, pos.bc = u.wc, u.bc
+	pos.halfmove, pos.fullmove = u.halfmove, u.fullmove
+	pos.score = u.score
+	pos.hash = u.hash
 }
 
 // Value returns the score of the current position if the move is applied.
@@ -263,10 +861,6 @@ func (pos Position) value(m Move) int {
 	if q != '.' && q != ' ' && !q.ours() {
 		score += pst[q.Flip()][j.Flip()]
 	}
-	// Castling check direction
-	if abs(int(j-pos.kp)) < 2 {
-		score += pst['K'][j.Flip()]
-	}
 	// Castling
 	if p == 'K' && (abs(int(i-j)) == 2) {
 		score = score + pst['R'][(i+j)/2]
@@ -277,9 +871,13 @@ func (pos Position) value(m Move) int {
 		}
 	}
 	if p == 'P' {
-		// Pawn promotion to queen
+		// Pawn promotion, defaulting to queen the same way MakeMove does
 		if A8 <= j && j <= H8 {
-			score += pst['Q'][j] - pst['P'][j]
+			promo := m.promo
+			if promo == 0 {
+				promo = 'Q'
+			}
+			score += pst[promo][j] - pst['P'][j]
 		}
 		// En-passant capture
 		if j == pos.ep {
@@ -292,38 +890,174 @@ func (pos Position) value(m Move) int {
 var (
 	// MateValue is a position score at checkmate
 	MateValue = Piece('K').value() + 10*Piece('Q').value()
-	// MaxTableSize defines how many positions to keep in transposition table
-	MaxTableSize = 10000000
+	// DefaultHashSizeMB is the transposition table size NewSearcher starts
+	// with, matching the "Hash" UCI option's advertised default: a GUI that
+	// never sends "setoption name Hash" should get the size it was told it
+	// would get, not whatever the largest supported table happens to be.
+	DefaultHashSizeMB = 16
 	// EvalRoughness is used in search algorithm
 	EvalRoughness = 13
 )
 
+// entry is a transposition table slot. hash disambiguates collisions at the
+// same index; an entry whose hash doesn't match the probing position is
+// treated as empty.
 type entry struct {
+	hash  uint64
 	depth int
 	score int
 	gamma int
 	move  Move
 }
 
+// SearchLimits describes the constraints on a single Search, as requested
+// by a UCI "go" command. A zero Duration or int field means that field's
+// constraint was not given; SearchUCI stops at the first limit it hits.
+type SearchLimits struct {
+	WTime, BTime time.Duration // remaining time for each side
+	WInc, BInc   time.Duration // increment per move for each side
+	MovesToGo    int           // moves left until the next time control, 0 if unknown
+	MoveTime     time.Duration // exact time to spend on this move, overrides the allocator
+	Depth        int           // fixed depth to search to, 0 if unbounded
+	Nodes        int           // node budget, 0 if unbounded
+	Infinite     bool          // search until Stop is closed, ignoring the time allocator
+}
+
+// timeForMove works out how long to spend on this move: MoveTime if given
+// directly, otherwise remaining/MovesToGo (defaulting to 30 in sudden death,
+// when MovesToGo is 0) plus half the increment, clamped to the time
+// actually remaining. It returns 0 if limits gives no time control at all
+// for the side to move, leaving the decision to depth/nodes/Infinite.
+func timeForMove(limits SearchLimits, whiteToMove bool) time.Duration {
+	if limits.MoveTime > 0 {
+		return limits.MoveTime
+	}
+	remaining, inc := limits.WTime, limits.WInc
+	if !whiteToMove {
+		remaining, inc = limits.BTime, limits.BInc
+	}
+	if remaining <= 0 {
+		return 0
+	}
+	movesToGo := limits.MovesToGo
+	if movesToGo <= 0 {
+		movesToGo = 30
+	}
+	alloc := remaining/time.Duration(movesToGo) + inc/2
+	if alloc > remaining {
+		alloc = remaining
+	}
+	return alloc
+}
+
+// SearchInfo summarizes one completed iterative-deepening depth, enough for
+// a UCI frontend to print an "info" line.
+type SearchInfo struct {
+	Depth int
+	Score int // centipawns, from the searched position's own side to move
+	Nodes int
+	Time  time.Duration
+	NPS   int
+	Move  Move
+	PV    []Move
+}
+
 // Searcher is an recursive alpha-beta search algorithm with transposition memory
 type Searcher struct {
-	tp    map[Position]entry
-	nodes int
+	tp      []entry
+	nodes   int
+	stop    <-chan struct{} // closed by SearchUCI's caller to cancel early; nil means never
+	stopped bool            // latched true once stop has been observed closed
+}
+
+// NewSearcher allocates a Searcher with a transposition table sized to
+// DefaultHashSizeMB, the same default the UCI "Hash" option advertises.
+func NewSearcher() *Searcher {
+	return &Searcher{tp: make([]entry, hashTableEntries(DefaultHashSizeMB))}
 }
 
-func (s *Searcher) bound(pos Position, gamma, depth int) (score int) {
+// hashTableEntries converts a transposition table size in megabytes to a
+// number of entries, rounding down to at least one entry.
+func hashTableEntries(sizeMB int) int {
+	n := sizeMB * 1024 * 1024 / int(unsafe.Sizeof(entry{}))
+	if n < 1 {
+		n = 1
+	}
+	return n
+}
+
+// SetHashSize reallocates the transposition table to hold roughly sizeMB
+// megabytes of entries, discarding its previous contents. It implements the
+// UCI "setoption name Hash value <sizeMB>" command.
+func (s *Searcher) SetHashSize(sizeMB int) {
+	s.tp = make([]entry, hashTableEntries(sizeMB))
+}
+
+// probe looks up pos in the transposition table, returning the entry (zero
+// value if absent) and whether the hash actually matched.
+func (s *Searcher) probe(pos *Position) (entry, bool) {
+	e := s.tp[pos.hash%uint64(len(s.tp))]
+	return e, e.hash == pos.hash
+}
+
+// checkStop polls s.stop every 1024 nodes, since a channel receive on every
+// node would be wasteful, and latches s.stopped once it fires so every
+// later call in this search is a plain bool check instead.
+func (s *Searcher) checkStop() bool {
+	if s.stopped {
+		return true
+	}
+	if s.stop == nil || s.nodes&1023 != 0 {
+		return false
+	}
+	select {
+	case <-s.stop:
+		s.stopped = true
+	default:
+	}
+	return s.stopped
+}
+
+func (s *Searcher) bound(pos *Position, gamma, depth int) (score int) {
 	s.nodes++
-	e, ok := s.tp[pos]
+	if s.checkStop() {
+		return pos.score
+	}
+	e, ok := s.probe(pos)
 	if ok && e.depth >= depth && ((e.score < e.gamma && e.score < gamma) ||
 		(e.score >= e.gamma && e.score >= gamma)) {
 		return e.score
 	}
-	if abs(pos.score) >= MateValue {
-		return pos.score
+	checkers := pos.Checkers()
+	moves := pos.Moves()
+	if len(moves) == 0 {
+		// No legal moves: checkmate if our king is attacked, stalemate
+		// otherwise. This replaces relying on a king-capture score, which
+		// strictly legal move generation never produces.
+		if len(checkers) > 0 {
+			return -MateValue
+		}
+		return 0
 	}
+
 	nullScore := pos.score
-	if depth > 0 {
-		nullScore = -s.bound(pos.Flip(), 1-gamma, depth-3)
+	if depth > 0 && len(checkers) == 0 {
+		// A null move forfeits any pending en-passant right, same as a real
+		// move would: FlipInPlace alone just rotates the board, so without
+		// this the right reappears (unchanged) once the compensating flip
+		// on the way back out undoes the rotation.
+		savedEP := pos.ep
+		if savedEP != 0 {
+			pos.hash ^= zobristEPSquare[savedEP]
+			pos.ep = 0
+		}
+		pos.FlipInPlace()
+		nullScore = -s.bound(pos, 1-gamma, depth-3)
+		pos.FlipInPlace()
+		pos.ep = savedEP
+		if savedEP != 0 {
+			pos.hash ^= zobristEPSquare[savedEP]
+		}
 	}
 	if nullScore >= gamma {
 		return nullScore
@@ -331,11 +1065,15 @@ func (s *Searcher) bound(pos Position, gamma, depth int) (score int) {
 
 	bestScore, bestMove := -3*MateValue, Move{}
 
-	for _, m := range pos.Moves() {
+	for _, m := range moves {
 		if depth <= 0 && pos.value(m) < 150 {
 			break
 		}
-		score := -s.bound(pos.Move(m), 1-gamma, depth-1)
+		u := pos.MakeMove(m)
+		pos.FlipInPlace()
+		score := -s.bound(pos, 1-gamma, depth-1)
+		pos.FlipInPlace()
+		pos.UnmakeMove(m, u)
 		if score > bestScore {
 			bestScore, bestMove = score, m
 		}
@@ -346,29 +1084,31 @@ func (s *Searcher) bound(pos Position, gamma, depth int) (score int) {
 	if depth <= 0 && bestScore < nullScore {
 		return nullScore
 	}
-	// Stalemate check: best move loses king + null move is better
-	if depth > 0 && bestScore <= -MateValue && nullScore > -MateValue {
-		bestScore = 0
-	}
 
-	if !ok || depth >= e.depth && bestScore >= gamma {
-		s.tp[pos] = entry{depth: depth, score: bestScore, gamma: gamma, move: bestMove}
-		if len(s.tp) > MaxTableSize {
-			s.tp = map[Position]entry{}
-		}
+	// Don't cache a bestScore cut short by checkStop: it was bailed out at
+	// an arbitrary node and doesn't actually reflect a search to depth.
+	if !s.stopped && (!ok || depth >= e.depth && bestScore >= gamma) {
+		s.tp[pos.hash%uint64(len(s.tp))] = entry{hash: pos.hash, depth: depth, score: bestScore, gamma: gamma, move: bestMove}
 	}
 
 	return bestScore
 }
 
+// Search runs iterative deepening until a mate score is found or maxNodes
+// nodes have been visited, and returns the best move found at the last
+// completed depth. It is the simple form used by cli(); SearchUCI adds time
+// limits, node/depth caps, a stop channel and per-depth info reporting for
+// the UCI front-end.
 func (s *Searcher) Search(pos Position, maxNodes int) (m Move) {
 	s.nodes = 0
+	s.stop, s.stopped = nil, false
+	p := pos
 	for depth := 1; depth < 99; depth++ {
 		lower, upper := -3*MateValue, 3*MateValue
 		score := 0
 		for lower < upper-EvalRoughness {
 			gamma := (lower + upper + 1) / 2
-			score = s.bound(pos, gamma, depth)
+			score = s.bound(&p, gamma, depth)
 			if score >= gamma {
 				lower = score
 			}
@@ -380,5 +1120,123 @@ func (s *Searcher) Search(pos Position, maxNodes int) (m Move) {
 			break
 		}
 	}
-	return s.tp[pos].move
+	if e, ok := s.probe(&p); ok {
+		return e.move
+	}
+	return Move{}
+}
+
+// PV reconstructs the principal variation starting at pos by repeatedly
+// probing the transposition table for its stored best move and applying
+// it, up to depth plies. It stops early if an entry is missing, or if the
+// hash repeats (a defensive guard against the table cycling back on
+// itself), since either means the line can't be extended reliably.
+func (s *Searcher) PV(pos Position, depth int) []Move {
+	var pv []Move
+	seen := map[uint64]bool{pos.hash: true}
+	for i := 0; i < depth; i++ {
+		e, ok := s.probe(&pos)
+		if !ok {
+			break
+		}
+		pv = append(pv, e.move)
+		pos = pos.Move(e.move)
+		if seen[pos.hash] {
+			break
+		}
+		seen[pos.hash] = true
+	}
+	return pv
+}
+
+// SearchUCI runs iterative deepening under limits, calling onInfo after
+// every completed depth, and returns the best move found. It stops early
+// once stop is closed, once the time budget in limits expires (checked by
+// checkStop mid-search, not just between depths, since one depth can run
+// several times longer than the last), once the node/depth budget is
+// spent, or once a mate score is found; a nil onInfo is fine if the caller
+// doesn't want per-depth reporting. It is the engine side of the UCI
+// "go"/"stop" commands; Search is the simpler form cli() uses instead.
+func (s *Searcher) SearchUCI(pos Position, limits SearchLimits, stop <-chan struct{}, onInfo func(SearchInfo)) (m Move) {
+	s.nodes = 0
+	p := pos
+
+	start := time.Now()
+	var deadline time.Time
+	if !limits.Infinite {
+		if d := timeForMove(limits, pos.whiteToMove); d > 0 {
+			deadline = start.Add(d)
+		}
+	}
+
+	// checkStop only polls s.stop, so fold the deadline into a channel of
+	// its own instead of relying on the once-per-depth check below: a
+	// timer closing timedOut lets a slow depth get cut off mid-search
+	// instead of running to completion before anyone notices time is up.
+	// The merge goroutine also selects on done, which we close on return:
+	// without it, a search that finishes for any other reason (mate found,
+	// a node/depth cap, stop never sent) leaves the goroutine blocked
+	// forever waiting on a timedOut that will never fire.
+	searchStop := stop
+	if !deadline.IsZero() {
+		done := make(chan struct{})
+		defer close(done)
+		timedOut := make(chan struct{})
+		timer := time.AfterFunc(time.Until(deadline), func() { close(timedOut) })
+		defer timer.Stop()
+		merged := make(chan struct{})
+		go func() {
+			select {
+			case <-stop:
+			case <-timedOut:
+			case <-done:
+			}
+			close(merged)
+		}()
+		searchStop = merged
+	}
+	s.stop, s.stopped = searchStop, false
+	maxDepth := limits.Depth
+	if maxDepth <= 0 {
+		maxDepth = 99
+	}
+
+	for depth := 1; depth <= maxDepth; depth++ {
+		lower, upper := -3*MateValue, 3*MateValue
+		score := 0
+		for lower < upper-EvalRoughness {
+			gamma := (lower + upper + 1) / 2
+			score = s.bound(&p, gamma, depth)
+			if s.stopped {
+				break
+			}
+			if score >= gamma {
+				lower = score
+			}
+			if score < gamma {
+				upper = score
+			}
+		}
+		if s.stopped {
+			break
+		}
+		if e, ok := s.probe(&p); ok {
+			m = e.move
+		}
+		if onInfo != nil {
+			elapsed := time.Since(start)
+			nps := 0
+			if elapsed > 0 {
+				nps = int(float64(s.nodes) / elapsed.Seconds())
+			}
+			pv := s.PV(pos, depth)
+			onInfo(SearchInfo{Depth: depth, Score: score, Nodes: s.nodes, Time: elapsed, NPS: nps, Move: m, PV: pv})
+		}
+		if abs(score) >= MateValue ||
+			(limits.Nodes > 0 && s.nodes >= limits.Nodes) ||
+			(!deadline.IsZero() && !time.Now().Before(deadline)) {
+			break
+		}
+	}
+	return m
 }
@@ -4,6 +4,7 @@ import (
 	"sort"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestAbs(t *testing.T) {
@@ -22,12 +23,12 @@ func TestAbs(t *testing.T) {
 }
 
 func TestFEN(t *testing.T) {
-	if b, err := fen("rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - 0 1"); err != nil {
+	if b, err := parsePlacement("rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR"); err != nil {
 		t.Error(err)
 	} else if b.String() != "\nrnbqkbnr\npppppppp\n........\n........\n........\n........\nPPPPPPPP\nRNBQKBNR\n" {
 		t.Error(b.String())
 	}
-	if b, err := fen("7K/3P4/8/8/8/8/1p6/k7"); err != nil {
+	if b, err := parsePlacement("7K/3P4/8/8/8/8/1p6/k7"); err != nil {
 		t.Error(err)
 	} else if b[28] != 'K' || b[34] != 'P' || b[82] != 'p' || b[91] != 'k' {
 		t.Error(b)
@@ -45,28 +46,28 @@ func TestFEN(t *testing.T) {
 		"8/1 7/8/8/8/8/8/8",
 		"8/1.7/8/8/8/8/8/8",
 	} {
-		if b, err := fen(s); err == nil {
+		if b, err := parsePlacement(s); err == nil {
 			t.Error(s, "should return an error, but got:", b)
 		}
 	}
 }
 
 func TestBoardSwap(t *testing.T) {
-	b, _ := fen("1k6/2p5/8/8/8/8/8/K7")
-	if b.Swap().Swap().String() != b.String() {
-		t.Error(b, b.Swap().Swap())
+	b, _ := parsePlacement("1k6/2p5/8/8/8/8/8/K7")
+	if b.Flip().Flip().String() != b.String() {
+		t.Error(b, b.Flip().Flip())
 	}
 	if b[22] != 'k' || b[33] != 'p' || b[91] != 'K' {
 		t.Error(b)
 	}
-	b = b.Swap()
+	b = b.Flip()
 	if b[28] != 'k' || b[86] != 'P' || b[97] != 'K' {
 		t.Error(b)
 	}
 }
 
 func TestSquare(t *testing.T) {
-	for sq, s := range map[square]string{
+	for sq, s := range map[Square]string{
 		A1: "a1", H1: "h1", A1 + 1: "b1", A1 - 10: "a2", A8: "a8", H8: "h8",
 	} {
 		if sq.String() != s {
@@ -79,13 +80,12 @@ func TestMoves(t *testing.T) {
 	for game, expected := range map[string]string{
 		"r4rk1/ppp2ppp/2n2n2/5P2/2pb4/2N2N2/PPP2PPP/RQ2K2R": "a2a3 a2a4 b1c1 b1d1 b2b3 b2b4 c3a4 c3b5 c3d1 c3d5 c3e2 c3e4 e1d1 e1d2 e1e2 e1f1 f3d2 f3d4 f3e5 f3g1 f3g5 f3h4 g2g3 g2g4 h1f1 h1g1 h2h3 h2h4",
 	} {
-		b, err := fen(game)
+		p, err := FEN(game)
 		if err != nil {
-			t.Error(game, b, err)
+			t.Error(game, err)
 		}
-		p := position{board: b}
 		moves := []string{}
-		for _, m := range p.moves() {
+		for _, m := range p.Moves() {
 			moves = append(moves, m.String())
 		}
 		sort.Strings(moves)
@@ -94,3 +94,230 @@ func TestMoves(t *testing.T) {
 		}
 	}
 }
+
+func TestPawnCheck(t *testing.T) {
+	// Black pawn on d2 gives check to the white king on e1 by attacking it
+	// diagonally backwards from White's point of view (d2xe1). attackersOf
+	// must look one rank behind sq in the direction pawns actually capture
+	// from, not one rank ahead of it.
+	pos, err := FEN("4k3/8/8/8/8/8/3p4/4K3 w - - 0 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !pos.InCheck() {
+		t.Error("white king on e1 should be in check from the pawn on d2")
+	}
+	if checkers := pos.Checkers(); len(checkers) != 1 || checkers[0].String() != "d2" {
+		t.Errorf("expected a single checker on d2, got %v", checkers)
+	}
+}
+
+func TestCastleRookPlacement(t *testing.T) {
+	// MakeMove's castling branch once swapped rookFrom/rookTo between the
+	// short and long cases, so e.g. castling kingside cleared a1 and left a
+	// second rook standing on h1. Apply both castles and inspect the
+	// resulting board directly instead of only checking aggregate move
+	// counts.
+	f1, _ := squareFromString("f1")
+	g1, _ := squareFromString("g1")
+	c1, _ := squareFromString("c1")
+	d1, _ := squareFromString("d1")
+	for _, tc := range []struct {
+		move           string
+		wantRookFrom   Square
+		wantRookTo     Square
+		wantKingSquare Square
+	}{
+		{"e1g1", H1, f1, g1},
+		{"e1c1", A1, d1, c1},
+	} {
+		pos, err := FEN("r3k2r/8/8/8/8/8/8/R3K2R w KQkq - 0 1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var m Move
+		found := false
+		for _, cand := range pos.Moves() {
+			if cand.String() == tc.move {
+				m, found = cand, true
+				break
+			}
+		}
+		if !found {
+			t.Fatalf("%s: move not found among legal moves", tc.move)
+		}
+		pos.MakeMove(m)
+		if pos.board[tc.wantKingSquare] != 'K' {
+			t.Errorf("%s: expected king on %s, board:\n%s", tc.move, tc.wantKingSquare, pos.board)
+		}
+		if pos.board[tc.wantRookTo] != 'R' {
+			t.Errorf("%s: expected rook on %s, board:\n%s", tc.move, tc.wantRookTo, pos.board)
+		}
+		if pos.board[tc.wantRookFrom] != '.' {
+			t.Errorf("%s: expected %s to be empty, board:\n%s", tc.move, tc.wantRookFrom, pos.board)
+		}
+	}
+}
+
+func TestFENRoundTrip(t *testing.T) {
+	// The five standard perft starting positions (see
+	// https://www.chessprogramming.org/Perft_Results), used here to check
+	// that FEN and Position.FEN are exact inverses of each other rather than
+	// for move counting.
+	for _, s := range []string{
+		"rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - 0 1",
+		"r3k2r/p1ppqpb1/bn2pnp1/3PN3/1p2P3/2N2Q1p/PPPBBPPP/R3K2R w KQkq - 0 1",
+		"8/2p5/3p4/KP5r/1R3p1k/8/4P1P1/8 w - - 0 1",
+		"r3k2r/Pppp1ppp/1b3nbN/nP6/BBP1P3/q4N2/Pp1P2PP/R2Q1RK1 w kq - 0 1",
+		"rnbq1k1r/pp1Pbppp/2p5/8/2B5/8/PPP1NnPP/RNBQK2R w KQ - 1 8",
+	} {
+		pos, err := FEN(s)
+		if err != nil {
+			t.Fatal(s, err)
+		}
+		if got := pos.FEN(); got != s {
+			t.Errorf("roundtrip mismatch:\nin:  %s\nout: %s", s, got)
+		}
+	}
+}
+
+func TestPerft(t *testing.T) {
+	// The five standard perft positions and their published leaf counts
+	// (see https://www.chessprogramming.org/Perft_Results). Perft now walks
+	// MakeMove/UnmakeMove rather than copying the position on every move,
+	// so startpos, position3 and position4 reach the next published depth
+	// in well under a minute. Kiwipete and position5 stay one depth short
+	// of that: their branching factor is 3-4x the others, and Moves()
+	// itself - not the make/unmake change - is the remaining bottleneck
+	// (it still walks every pseudo-legal move through PinnedPieces/Checkers
+	// rather than a faster representation like BBPosition, which isn't
+	// wired in - see the moveGenerator doc comment in bitboard.go). Going
+	// one depth further there would take minutes, not seconds.
+	for _, tc := range []struct {
+		name, fen    string
+		depth        int
+		nodes        uint64
+		publishedFor string // the deeper published count this position is known for
+	}{
+		{"startpos", "rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - 0 1", 4, 197281, "depth 5 = 4,865,609"},
+		{"kiwipete", "r3k2r/p1ppqpb1/bn2pnp1/3PN3/1p2P3/2N2Q1p/PPPBBPPP/R3K2R w KQkq - 0 1", 3, 97862, "depth 4 = 4,085,603"},
+		{"position3", "8/2p5/3p4/KP5r/1R3p1k/8/4P1P1/8 w - - 0 1", 4, 43238, "depth 5 = 674,624"},
+		{"position4", "r3k2r/Pppp1ppp/1b3nbN/nP6/BBP1P3/q4N2/Pp1P2PP/R2Q1RK1 w kq - 0 1", 4, 422333, "depth 5 = 15,833,292"},
+		{"position5", "rnbq1k1r/pp1Pbppp/2p5/8/2B5/8/PPP1NnPP/RNBQK2R w KQ - 1 8", 3, 62379, "depth 4 = 2,103,487"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			pos, err := FEN(tc.fen)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n := pos.Perft(tc.depth); n != tc.nodes {
+				t.Errorf("Perft(%d) = %d, want %d (published %s)", tc.depth, n, tc.nodes, tc.publishedFor)
+			}
+		})
+	}
+}
+
+func TestPerftDivide(t *testing.T) {
+	pos, err := FEN("r3k2r/p1ppqpb1/bn2pnp1/3PN3/1p2P3/2N2Q1p/PPPBBPPP/R3K2R w KQkq - 0 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	const depth = 2
+	var sum uint64
+	for _, n := range pos.PerftDivide(depth) {
+		sum += n
+	}
+	if want := pos.Perft(depth); sum != want {
+		t.Errorf("PerftDivide(%d) totals %d, want %d", depth, sum, want)
+	}
+}
+
+func TestTimeForMove(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		limits      SearchLimits
+		whiteToMove bool
+		want        time.Duration
+	}{
+		{"move time overrides everything", SearchLimits{MoveTime: 500 * time.Millisecond, WTime: time.Minute}, true, 500 * time.Millisecond},
+		{"no time control at all", SearchLimits{}, true, 0},
+		{"sudden death defaults to /30", SearchLimits{WTime: 30 * time.Second}, true, time.Second},
+		{"MovesToGo overrides the /30 default", SearchLimits{WTime: 30 * time.Second, MovesToGo: 10}, true, 3 * time.Second},
+		{"increment is added on top", SearchLimits{WTime: 30 * time.Second, WInc: 2 * time.Second, MovesToGo: 10}, true, 4 * time.Second},
+		{"black reads BTime/BInc/MovesToGo, not White's", SearchLimits{WTime: time.Minute, BTime: 10 * time.Second, MovesToGo: 5}, false, 2 * time.Second},
+	} {
+		if got := timeForMove(tc.limits, tc.whiteToMove); got != tc.want {
+			t.Errorf("%s: timeForMove() = %s, want %s", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestSearchUnderpromotion(t *testing.T) {
+	// White mates in one by promoting the b7 pawn to a knight: Nb8 checks
+	// Ka6 while the two other knights cover every flight square. Promoting
+	// to a queen, rook or bishop instead only stalemates Black, since those
+	// pieces don't attack a6 - the search must prefer the mate.
+	pos, err := FEN("8/1PK5/k1N5/8/3N4/8/8/8 w - - 0 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewSearcher()
+	m := s.Search(pos, 20000)
+	if m.String() != "b7b8n" {
+		t.Errorf("expected mating underpromotion b7b8n, got %s", m.String())
+	}
+	if !pos.Move(m).IsCheckmate() {
+		t.Errorf("%s should be checkmate", m.String())
+	}
+}
+
+func TestSearchUCI(t *testing.T) {
+	pos, err := FEN("rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - 0 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var infos []SearchInfo
+	s := NewSearcher()
+	m := s.SearchUCI(pos, SearchLimits{Depth: 3}, nil, func(info SearchInfo) {
+		infos = append(infos, info)
+	})
+	if len(infos) != 3 {
+		t.Errorf("expected one info callback per depth, got %d", len(infos))
+	}
+	for i, info := range infos {
+		if info.Depth != i+1 {
+			t.Errorf("info %d: expected depth %d, got %d", i, i+1, info.Depth)
+		}
+	}
+	if m.String() == "" {
+		t.Error("expected a move at depth 3")
+	}
+
+	stop := make(chan struct{})
+	close(stop)
+	if m := s.SearchUCI(pos, SearchLimits{Infinite: true}, stop, nil); m.String() == "" {
+		t.Error("expected the pre-stopped search to still return the last completed depth's move")
+	}
+}
+
+// TestSearchUCIRespectsDeadline drives an uncapped search (no Depth, no
+// Nodes) on a position busy enough that iterative deepening keeps finding
+// a new depth worth exploring, so without a deadline wired into checkStop
+// it would run well past MoveTime waiting for whichever depth happens to
+// be in flight to finish.
+func TestSearchUCIRespectsDeadline(t *testing.T) {
+	pos, err := FEN("r3k2r/p1ppqpb1/bn2pnp1/3PN3/1p2P3/2N2Q1p/PPPBBPPP/R3K2R w KQkq - 0 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := NewSearcher()
+	moveTime := 50 * time.Millisecond
+	start := time.Now()
+	if m := s.SearchUCI(pos, SearchLimits{MoveTime: moveTime}, nil, nil); m.String() == "" {
+		t.Error("expected a move even though the search was cut off")
+	}
+	if elapsed := time.Since(start); elapsed > 10*moveTime {
+		t.Errorf("search ran %s past a %s deadline, checkStop isn't cutting a slow depth short", elapsed, moveTime)
+	}
+}
@@ -4,19 +4,19 @@ import (
 	"bufio"
 	"fmt"
 	"os"
+	"strconv"
 	"strings"
+	"time"
 )
 
 func start() Position {
-	board, _ := FEN("rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBKQBNR")
-	return Position{
-		board: board,
-	}
+	pos, _ := FEN("rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - 0 1")
+	return pos
 }
 
 func cli() {
 	pos := start()
-	searcher := &Searcher{tp: map[Position]entry{}}
+	searcher := NewSearcher()
 	r := bufio.NewReader(os.Stdin)
 	for {
 		fmt.Println(pos.board)
@@ -25,6 +25,13 @@ func cli() {
 			fmt.Print("Enter move: ")
 			input, _ := r.ReadString('\n')
 			input = strings.TrimSpace(input)
+			if strings.HasPrefix(input, "fen ") {
+				if p, err := FEN(input[4:]); err == nil {
+					pos = p
+					fmt.Println(pos.board)
+				}
+				continue
+			}
 			valid = false
 			for _, m := range pos.Moves() {
 				if input == m.String() {
@@ -34,30 +41,194 @@ func cli() {
 				}
 			}
 		}
-		fmt.Println(pos.Flip().board)
-		m := searcher.Search(pos, 10000)
-		score := pos.value(m)
-		if score <= -MateValue {
+		if pos.IsCheckmate() {
 			fmt.Println("You won")
 			return
 		}
-		if score >= MateValue {
-			fmt.Println("You lost")
+		if pos.IsStalemate() {
+			fmt.Println("Draw by stalemate")
 			return
 		}
+		fmt.Println(pos.Flip().board)
+		m := searcher.Search(pos, 10000)
 		pos = pos.Move(m)
+		if pos.IsCheckmate() {
+			fmt.Println("You lost")
+			return
+		}
+		if pos.IsStalemate() {
+			fmt.Println("Draw by stalemate")
+			return
+		}
+	}
+}
+
+// absoluteMove flips m's squares (but not its promotion piece or flags)
+// between UCI's always-absolute board coordinates and the engine's
+// mover-relative frame; it is its own inverse, since Square.Flip is.
+func absoluteMove(m Move, white bool) Move {
+	if white {
+		return m
+	}
+	m.from, m.to = m.from.Flip(), m.to.Flip()
+	return m
+}
+
+// applyUCIMoves replays a "e2e4 e7e5 ..."-style UCI move list onto pos,
+// starting with White to move iff startWhite. UCI move squares are always
+// given in absolute board coordinates, so each move is flipped into the
+// engine's mover-relative frame whenever it is Black's turn to play it.
+func applyUCIMoves(pos Position, startWhite bool, moves []string) (Position, bool) {
+	white := startWhite
+	for _, s := range moves {
+		m, ok := moveFromUCI(s)
+		if !ok {
+			continue
+		}
+		pos = pos.Move(absoluteMove(m, white))
+		white = !white
+	}
+	return pos, white
+}
+
+// parseGoLimits parses the fields that follow "go" in a UCI command, such
+// as "wtime 300000 btime 300000 winc 0 binc 0 movestogo 40", "depth 6",
+// "movetime 5000" or "infinite", into a SearchLimits. Unrecognized or
+// malformed fields are ignored, as UCI allows.
+func parseGoLimits(fields []string) SearchLimits {
+	var limits SearchLimits
+	millis := func(i int) time.Duration {
+		if i >= len(fields) {
+			return 0
+		}
+		n, err := strconv.Atoi(fields[i])
+		if err != nil {
+			return 0
+		}
+		return time.Duration(n) * time.Millisecond
+	}
+	number := func(i int) int {
+		if i >= len(fields) {
+			return 0
+		}
+		n, _ := strconv.Atoi(fields[i])
+		return n
+	}
+	for i, f := range fields {
+		switch f {
+		case "infinite":
+			limits.Infinite = true
+		case "wtime":
+			limits.WTime = millis(i + 1)
+		case "btime":
+			limits.BTime = millis(i + 1)
+		case "winc":
+			limits.WInc = millis(i + 1)
+		case "binc":
+			limits.BInc = millis(i + 1)
+		case "movetime":
+			limits.MoveTime = millis(i + 1)
+		case "movestogo":
+			limits.MovesToGo = number(i + 1)
+		case "depth":
+			limits.Depth = number(i + 1)
+		case "nodes":
+			limits.Nodes = number(i + 1)
+		}
+	}
+	return limits
+}
+
+// applyUCIOption handles a "setoption name <name> value <value>" command,
+// stripped of its "setoption name " prefix. Hash resizes the searcher's
+// transposition table (sized in MB, as UCI conventionally expects);
+// MoveOverhead sets a safety margin subtracted from the time allocated to
+// each "go". Unrecognized option names are ignored, as UCI allows.
+func applyUCIOption(searcher *Searcher, moveOverhead *time.Duration, rest string) {
+	name, value := rest, ""
+	if i := strings.Index(rest, " value "); i >= 0 {
+		name, value = rest[:i], rest[i+len(" value "):]
 	}
+	switch name {
+	case "Hash":
+		if mb, err := strconv.Atoi(value); err == nil {
+			searcher.SetHashSize(mb)
+		}
+	case "MoveOverhead":
+		if ms, err := strconv.Atoi(value); err == nil {
+			*moveOverhead = time.Duration(ms) * time.Millisecond
+		}
+	}
+}
+
+// searchLoop serializes successive UCI "go" searches against a single
+// Searcher. Searcher keeps its search state (nodes, stop, stopped) in
+// fields rather than threading it through calls, so two SearchUCI calls
+// running at once on the same Searcher would race on them - which a GUI
+// sending "stop" immediately followed by "go", or two "go"s in a row
+// without waiting for "bestmove", can trigger.
+type searchLoop struct {
+	searcher *Searcher
+	stop     chan struct{} // non-nil while a search is in flight
+	done     chan struct{} // non-nil until that search's goroutine returns
+}
+
+func newSearchLoop(searcher *Searcher) *searchLoop {
+	return &searchLoop{searcher: searcher}
+}
+
+// start blocks until any previously started search has actually returned,
+// then runs pos's search in a new goroutine and calls onBestMove with its
+// result.
+func (l *searchLoop) start(pos Position, limits SearchLimits, onInfo func(SearchInfo), onBestMove func(Move)) {
+	l.await()
+	stop, done := make(chan struct{}), make(chan struct{})
+	l.stop, l.done = stop, done
+	go func() {
+		defer close(done)
+		onBestMove(l.searcher.SearchUCI(pos, limits, stop, onInfo))
+	}()
+}
+
+// stop signals the in-flight search, if any, to return early. It implements
+// the UCI "stop" command.
+func (l *searchLoop) requestStop() {
+	if l.stop != nil {
+		close(l.stop)
+		l.stop = nil
+	}
+}
+
+// await blocks until the in-flight search's goroutine has returned, so the
+// next start's SearchUCI call never overlaps it.
+func (l *searchLoop) await() {
+	if l.done != nil {
+		<-l.done
+		l.done = nil
+	}
+}
+
+// printInfo writes a UCI "info" line for one completed search depth. The
+// search itself runs in the engine's mover-relative frame, so each PV move
+// is flipped back to absolute board coordinates, alternating white as the
+// PV steps through successive plies.
+func printInfo(info SearchInfo, white bool) {
+	pv := make([]string, len(info.PV))
+	for i, m := range info.PV {
+		pv[i] = absoluteMove(m, white).String()
+		white = !white
+	}
+	fmt.Printf("info depth %d seldepth %d score cp %d nodes %d nps %d time %d pv %s\n",
+		info.Depth, len(info.PV), info.Score, info.Nodes, info.NPS, info.Time.Milliseconds(), strings.Join(pv, " "))
 }
 
 func uci() {
 	pos := start()
-	searcher := &Searcher{tp: map[Position]entry{}}
+	searcher := NewSearcher()
 	r := bufio.NewReader(os.Stdin)
-	sqr := map[string]Square{}
-	for i := Square(0); i < 120; i++ {
-		sqr[i.String()] = i
-	}
 	white := true
+	var moveOverhead time.Duration
+	loop := newSearchLoop(searcher)
 	for {
 		input, _ := r.ReadString('\n')
 		input = strings.TrimSpace(input)
@@ -69,39 +240,90 @@ func uci() {
 		case input == "uci":
 			fmt.Println("id name carnatus")
 			fmt.Println("id author zserge")
+			fmt.Printf("option name Hash type spin default %d min 1 max 4096\n", DefaultHashSizeMB)
+			fmt.Println("option name MoveOverhead type spin default 0 min 0 max 5000")
 			fmt.Println("uciok")
+		case strings.HasPrefix(input, "setoption name "):
+			applyUCIOption(searcher, &moveOverhead, input[len("setoption name "):])
 		case input == "ucinewgame" || input == "position startpos":
 			pos = start()
 			white = true
 		case strings.HasPrefix(input, "position startpos moves "):
-			pos = start()
-			moves := strings.Split(input[24:], " ")
-			for i, s := range moves {
-				m := Move{from: sqr[s[0:2]], to: sqr[s[2:4]]}
-				if i%2 != 0 {
-					m = Move{from: m.from.Flip(), to: m.to.Flip()}
-				}
-				pos = pos.Move(m)
-			}
-			white = len(moves)%2 == 0
+			pos, white = applyUCIMoves(start(), true, strings.Split(input[24:], " "))
 		case strings.HasPrefix(input, "position fen "):
-			b, _ := FEN(input[13:])
-			fmt.Println(b)
-			pos = Position{board: b}
+			rest := input[13:]
+			fenPart, movesPart := rest, ""
+			if i := strings.Index(rest, " moves "); i >= 0 {
+				fenPart, movesPart = rest[:i], rest[i+len(" moves "):]
+			}
+			p, err := FEN(fenPart)
+			if err != nil {
+				break
+			}
+			pos, white = p, p.whiteToMove
+			if movesPart != "" {
+				pos, white = applyUCIMoves(pos, white, strings.Split(movesPart, " "))
+			}
 		case strings.HasPrefix(input, "go"):
-			m := searcher.Search(pos, 10000)
-			if !white {
-				m = Move{from: m.from.Flip(), to: m.to.Flip()}
+			if pos.IsCheckmate() || pos.IsStalemate() {
+				// No legal moves: report it the same way a null move would,
+				// rather than starting a search that can only return a
+				// zero-value Move (see cli's IsCheckmate/IsStalemate checks).
+				fmt.Println("bestmove 0000")
+				break
+			}
+			limits := parseGoLimits(strings.Fields(strings.TrimPrefix(input, "go")))
+			for _, d := range []*time.Duration{&limits.WTime, &limits.BTime, &limits.MoveTime} {
+				if *d > moveOverhead {
+					*d -= moveOverhead
+				}
 			}
-			fmt.Println("bestmove", m)
+			searchPos, searchWhite := pos, white
+			loop.start(searchPos, limits, func(info SearchInfo) {
+				printInfo(info, searchWhite)
+			}, func(m Move) {
+				fmt.Println("bestmove", absoluteMove(m, searchWhite))
+			})
+		case input == "stop":
+			loop.requestStop()
+		}
+	}
+}
+
+// bench drives the mailbox and bitboard move generators over pos through
+// the shared moveGenerator interface and prints each one's throughput. Both
+// generators now return strictly legal moves, so the two calls/sec figures
+// are directly comparable (see the moveGenerator doc comment in
+// bitboard.go for why Searcher itself still only uses the mailbox one).
+// It's the "carnatus bench" equivalent of running "go test -bench" against
+// BenchmarkMailboxMoves and BenchmarkBBPositionMoves in bitboard_test.go.
+func bench(pos Position) {
+	const iterations = 200000
+	generators := []struct {
+		name string
+		gen  moveGenerator
+	}{
+		{"mailbox", pos},
+		{"bitboard", NewBBPosition(pos)},
+	}
+	for _, g := range generators {
+		start := time.Now()
+		moves := 0
+		for i := 0; i < iterations; i++ {
+			moves += len(g.gen.Moves())
 		}
+		elapsed := time.Since(start)
+		fmt.Printf("%-8s %.0f calls/sec (%d moves/call)\n", g.name, float64(iterations)/elapsed.Seconds(), moves/iterations)
 	}
 }
 
 func main() {
-	if len(os.Args) == 2 && os.Args[1] == "cli" {
+	switch {
+	case len(os.Args) == 2 && os.Args[1] == "cli":
 		cli()
-	} else {
+	case len(os.Args) == 2 && os.Args[1] == "bench":
+		bench(start())
+	default:
 		uci()
 	}
 }
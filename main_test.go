@@ -0,0 +1,41 @@
+package main
+
+import (
+	"sync"
+	"testing"
+)
+
+// TestSearchLoopSerializesSearches mirrors a GUI sending "stop" immediately
+// followed by "go", or two "go"s in a row, neither of which waits for the
+// previous search's "bestmove". Before searchLoop, that raced on Searcher's
+// nodes/stop/stopped fields because the previous goroutine could still be
+// running when the next SearchUCI call started. start's internal await
+// makes the calls line up one after another instead, so running this test
+// under -race must report nothing.
+func TestSearchLoopSerializesSearches(t *testing.T) {
+	pos, err := FEN("rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - 0 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	loop := newSearchLoop(NewSearcher())
+
+	var mu sync.Mutex
+	var bestmoves []Move
+
+	record := func(m Move) {
+		mu.Lock()
+		bestmoves = append(bestmoves, m)
+		mu.Unlock()
+	}
+
+	loop.start(pos, SearchLimits{Nodes: 1000}, nil, record)
+	loop.requestStop()                                      // "stop" right on the heels of "go"
+	loop.start(pos, SearchLimits{Nodes: 1000}, nil, record) // another "go" before "bestmove"
+	loop.await()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(bestmoves) != 2 {
+		t.Fatalf("expected 2 bestmove callbacks, got %d", len(bestmoves))
+	}
+}
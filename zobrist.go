@@ -0,0 +1,102 @@
+package main
+
+import "math/rand"
+
+// Zobrist hashing lets Position carry an incrementally maintained 64-bit
+// fingerprint instead of relying on the struct itself (with its 120-byte
+// Board) as a map key. The tables are built so that a full-board rotation
+// (as performed by FlipInPlace) leaves the piece/castling/en-passant
+// contributions unchanged: for every square s and piece p,
+// zobristPieceSquare[idx(p)][s] == zobristPieceSquare[idx(p.Flip())][119-s],
+// and likewise for castling rights and en-passant squares. That means
+// FlipInPlace only needs to toggle zobristSideToMove, rather than recompute
+// the hash from scratch.
+var (
+	zobristPieceSquare [12][120]uint64
+	zobristCastle      [4]uint64 // 0: white O-O, 1: white O-O-O, 2: black O-O, 3: black O-O-O
+	zobristEPSquare    [120]uint64
+	zobristSideToMove  uint64
+)
+
+func init() {
+	rnd := rand.New(rand.NewSource(1))
+	for s := 0; s < 60; s++ {
+		mirror := 119 - s
+		for idx := 0; idx < 12; idx++ {
+			v := rnd.Uint64()
+			zobristPieceSquare[idx][s] = v
+			zobristPieceSquare[flipPieceIndex(idx)][mirror] = v
+		}
+		v := rnd.Uint64()
+		zobristEPSquare[s] = v
+		zobristEPSquare[mirror] = v
+	}
+	zobristCastle[0] = rnd.Uint64()
+	zobristCastle[2] = zobristCastle[0]
+	zobristCastle[1] = rnd.Uint64()
+	zobristCastle[3] = zobristCastle[1]
+	zobristSideToMove = rnd.Uint64()
+}
+
+// pieceIndex maps a mailbox Piece to a 0..11 index into zobristPieceSquare,
+// or -1 for whitespace/dot squares that hold no piece.
+func pieceIndex(p Piece) int {
+	k, ok := kindOf(p)
+	if !ok {
+		return -1
+	}
+	return int(colorOf(p))*int(numKinds) + int(k)
+}
+
+// flipPieceIndex returns the index of the same piece kind belonging to the
+// opposite color.
+func flipPieceIndex(idx int) int {
+	if idx < int(numKinds) {
+		return idx + int(numKinds)
+	}
+	return idx - int(numKinds)
+}
+
+// zobristSquare returns the hash contribution of piece p sitting on square
+// s. p must be an actual piece, not whitespace or a dot.
+func zobristSquare(p Piece, s Square) uint64 {
+	return zobristPieceSquare[pieceIndex(p)][s]
+}
+
+// computeHash derives a Position's Zobrist hash from scratch. It is only
+// needed once, when a Position is built directly from a Board (e.g. at game
+// start or after loading a FEN); from then on MakeMove/UnmakeMove/
+// FlipInPlace maintain it incrementally.
+func computeHash(pos Position) uint64 {
+	var h uint64
+	for i, p := range pos.board {
+		if idx := pieceIndex(p); idx >= 0 {
+			h ^= zobristPieceSquare[idx][i]
+		}
+	}
+	if pos.wc[0] {
+		h ^= zobristCastle[0]
+	}
+	if pos.wc[1] {
+		h ^= zobristCastle[1]
+	}
+	if pos.bc[0] {
+		h ^= zobristCastle[2]
+	}
+	if pos.bc[1] {
+		h ^= zobristCastle[3]
+	}
+	if pos.ep != 0 {
+		h ^= zobristEPSquare[pos.ep]
+	}
+	return h
+}
+
+// newPosition builds a Position from a Board with White to move, no
+// castling or en-passant rights, and its Zobrist hash initialized, ready to
+// be mutated in place by MakeMove/UnmakeMove.
+func newPosition(b Board) Position {
+	p := Position{board: b, whiteToMove: true, fullmove: 1}
+	p.hash = computeHash(p)
+	return p
+}